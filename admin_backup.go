@@ -0,0 +1,53 @@
+package main
+
+import "net/http"
+
+// BackupHandler streams a consistent snapshot of store to the response
+// body, after requiring the request's HTTP Basic Authorization header to
+// resolve to a RoleAdmin user. See RegisterAdminRoutes for where it's
+// mounted (GET /admin/backup).
+func BackupHandler(store MetaStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if _, err := authenticateRequest(store, r.Header.Get("Authorization"), RoleAdmin); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+
+		if _, err := store.Backup(w); err != nil {
+			// Backup may have already streamed part of the snapshot by the
+			// time it fails, so headers are likely committed; just log
+			// rather than attempting to set a status/body on top of it.
+			logger.Log(kv{"fn": "admin_backup", "msg": err.Error()})
+		}
+	}
+}
+
+// RestoreHandler replaces store's entire contents with the snapshot
+// uploaded in the request body, after requiring the request's HTTP Basic
+// Authorization header to resolve to a RoleAdmin user. See
+// RegisterAdminRoutes for where it's mounted (POST /admin/restore).
+func RestoreHandler(store MetaStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if _, err := authenticateRequest(store, r.Header.Get("Authorization"), RoleAdmin); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		if err := store.Restore(r.Body); err != nil {
+			logger.Log(kv{"fn": "admin_restore", "msg": err.Error()})
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
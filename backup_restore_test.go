@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMemoryMetaStore_BackupRestore(t *testing.T) {
+	s, v := newTestMemoryMetaStore(t)
+
+	if _, err := s.Put(v); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	var snapshot bytes.Buffer
+	if _, err := s.Backup(&snapshot); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+
+	restored := NewMemoryMetaStore()
+	if err := restored.Restore(&snapshot); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	got, err := restored.Get(v)
+	if err != nil {
+		t.Fatalf("Get after Restore: %v", err)
+	}
+	if got.Oid != v.Oid || got.Size != v.Size {
+		t.Fatalf("restored object = %+v, want Oid=%q Size=%d", got, v.Oid, v.Size)
+	}
+
+	if _, err := restored.Authenticate("writer", "pass"); err != nil {
+		t.Fatalf("Authenticate after Restore: %v", err)
+	}
+}
+
+func TestBackupRestoreHandlers_RequireAdminAuth(t *testing.T) {
+	s := NewMemoryMetaStore()
+	if err := s.AddUser("writer", "pass", RoleWriter); err != nil {
+		t.Fatalf("AddUser: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/backup", nil)
+	req.Header.Set("Authorization", basicAuthHeader("writer", "pass"))
+	rec := httptest.NewRecorder()
+
+	BackupHandler(s)(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("BackupHandler with writer credentials = status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	restoreReq := httptest.NewRequest(http.MethodPost, "/admin/restore", bytes.NewReader(nil))
+	restoreReq.Header.Set("Authorization", basicAuthHeader("writer", "pass"))
+	restoreRec := httptest.NewRecorder()
+
+	RestoreHandler(s)(restoreRec, restoreReq)
+
+	if restoreRec.Code != http.StatusUnauthorized {
+		t.Fatalf("RestoreHandler with writer credentials = status %d, want %d", restoreRec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestBackupRestoreHandlers_RoundTrip(t *testing.T) {
+	s, v := newTestMemoryMetaStore(t)
+	if err := s.AddUser("admin", "pass", RoleAdmin); err != nil {
+		t.Fatalf("AddUser: %v", err)
+	}
+	if _, err := s.Put(v); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	backupReq := httptest.NewRequest(http.MethodGet, "/admin/backup", nil)
+	backupReq.Header.Set("Authorization", basicAuthHeader("admin", "pass"))
+	backupRec := httptest.NewRecorder()
+
+	BackupHandler(s)(backupRec, backupReq)
+	if backupRec.Code != http.StatusOK {
+		t.Fatalf("BackupHandler = status %d, want %d", backupRec.Code, http.StatusOK)
+	}
+
+	restored := NewMemoryMetaStore()
+	if err := restored.AddUser("admin", "pass", RoleAdmin); err != nil {
+		t.Fatalf("AddUser on restore target: %v", err)
+	}
+
+	restoreReq := httptest.NewRequest(http.MethodPost, "/admin/restore", backupRec.Body)
+	restoreReq.Header.Set("Authorization", basicAuthHeader("admin", "pass"))
+	restoreRec := httptest.NewRecorder()
+
+	RestoreHandler(restored)(restoreRec, restoreReq)
+	if restoreRec.Code != http.StatusOK {
+		t.Fatalf("RestoreHandler = status %d, want %d", restoreRec.Code, http.StatusOK)
+	}
+
+	got, err := restored.Get(v)
+	if err != nil {
+		t.Fatalf("Get after round trip: %v", err)
+	}
+	if got.Oid != v.Oid {
+		t.Fatalf("restored object Oid = %q, want %q", got.Oid, v.Oid)
+	}
+}
@@ -0,0 +1,922 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BoltMetaStore implements MetaStore. It stores user credentials and Meta
+// information for objects. The storage is handled by boltdb.
+type BoltMetaStore struct {
+	path string
+
+	// dbMu guards db itself (not its contents, which boltdb already
+	// serializes): every other method holds a read lock for the duration
+	// of its View/Update call, while Restore holds the write lock while it
+	// swaps in a freshly reopened *bolt.DB, and Close holds it while it
+	// closes the database.
+	dbMu sync.RWMutex
+	db   *bolt.DB
+}
+
+// viewDB runs fn in a db.View transaction, guarding against a concurrent
+// Restore swapping out the underlying *bolt.DB mid-call.
+func (s *BoltMetaStore) viewDB(fn func(tx *bolt.Tx) error) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return s.db.View(fn)
+}
+
+// updateDB runs fn in a db.Update transaction, guarding against a
+// concurrent Restore swapping out the underlying *bolt.DB mid-call.
+func (s *BoltMetaStore) updateDB(fn func(tx *bolt.Tx) error) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return s.db.Update(fn)
+}
+
+var (
+	usersBucket = []byte("users")
+
+	objectsBucket = []byte("objects")
+
+	// objectsByUserBucket and objectsByTimeBucket are secondary indexes over
+	// objectsBucket, maintained in the same transaction as the Put that
+	// creates the primary entry. They hold no values of their own, just keys
+	// whose ordering lets a boltdb cursor walk a prefix without decoding
+	// every MetaObject in the bucket.
+	objectsByUserBucket = []byte("objects_by_user")
+	objectsByTimeBucket = []byte("objects_by_time")
+)
+
+// NewBoltMetaStore creates a new BoltMetaStore using the boltdb database at dbFile.
+func NewBoltMetaStore(dbFile string) (*BoltMetaStore, error) {
+	db, err := bolt.Open(dbFile, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(usersBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(objectsBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(objectsByUserBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(objectsByTimeBucket); err != nil {
+			return err
+		}
+
+		return nil
+	})
+
+	return &BoltMetaStore{db: db, path: dbFile}, nil
+}
+
+// Backup opens a db.View transaction and streams a consistent, point-in-time
+// snapshot of the boltdb file to w via bolt's native tx.WriteTo, without
+// blocking concurrent reads or writes.
+func (s *BoltMetaStore) Backup(w io.Writer) (int64, error) {
+	var n int64
+	err := s.viewDB(func(tx *bolt.Tx) error {
+		written, err := tx.WriteTo(w)
+		n = written
+		return err
+	})
+	return n, err
+}
+
+// Restore replaces the boltdb file with a snapshot previously written by
+// Backup: it writes r to a temp file alongside the database, closes the
+// current database handle, atomically renames the temp file into place,
+// then reopens it. It holds dbMu for the duration, so viewDB/updateDB
+// callers block until the swap completes rather than racing the close.
+func (s *BoltMetaStore) Restore(r io.Reader) error {
+	tmpPath := s.path + ".restore.tmp"
+
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+
+	if err := s.db.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return err
+	}
+
+	db, err := bolt.Open(s.path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return err
+	}
+
+	s.db = db
+	return nil
+}
+
+// getMetaObject reads and gob-decodes the MetaObject stored under oid, with
+// no authentication check; it's the internal primitive Get and Put build
+// on, so Put can check existence without paying for a second bcrypt
+// comparison.
+func (s *BoltMetaStore) getMetaObject(oid string) (*MetaObject, error) {
+	var meta MetaObject
+	err := s.viewDB(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(objectsBucket)
+		if bucket == nil {
+			return errNoBucket
+		}
+
+		value := bucket.Get([]byte(oid))
+		if len(value) == 0 {
+			return errObjectNotFound
+		}
+
+		dec := gob.NewDecoder(bytes.NewBuffer(value))
+		return dec.Decode(&meta)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// Get retrieves the Meta information for an object given information in
+// RequestVars
+func (s *BoltMetaStore) Get(v *RequestVars) (*MetaObject, error) {
+	if _, err := authenticateRequest(s, v.Authorization, RoleReader); err != nil {
+		return nil, err
+	}
+
+	meta, err := s.getMetaObject(v.Oid)
+	if err != nil {
+		logger.Log(kv{"fn": "meta_store", "msg": err.Error()})
+		return nil, err
+	}
+
+	meta.LastAccessAt = time.Now()
+	go s.touchLastAccess(v.Oid, meta.LastAccessAt)
+
+	return meta, nil
+}
+
+// touchLastAccess persists LastAccessAt for oid in its own Update
+// transaction, off the Get call path, so bumping it on every read doesn't
+// serialize downloads behind boltdb's single writer lock. It's best-effort:
+// a failure (including the store being closed mid-flight) is logged and
+// otherwise ignored.
+func (s *BoltMetaStore) touchLastAccess(oid string, accessedAt time.Time) {
+	err := s.updateDB(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(objectsBucket)
+		if bucket == nil {
+			return errNoBucket
+		}
+
+		value := bucket.Get([]byte(oid))
+		if len(value) == 0 {
+			return errObjectNotFound
+		}
+
+		var meta MetaObject
+		if err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(&meta); err != nil {
+			return err
+		}
+		meta.LastAccessAt = accessedAt
+
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(meta); err != nil {
+			return err
+		}
+		return bucket.Put([]byte(oid), buf.Bytes())
+	})
+
+	if err != nil {
+		logger.Log(kv{"fn": "meta_store", "msg": err.Error()})
+	}
+}
+
+// Put writes meta information from RequestVars to the store.
+func (s *BoltMetaStore) Put(v *RequestVars) (*MetaObject, error) {
+	if _, err := authenticateRequest(s, v.Authorization, RoleWriter); err != nil {
+		return nil, err
+	}
+
+	// Check if it exists first
+	if meta, err := s.getMetaObject(v.Oid); err == nil {
+		meta.Existing = true
+		return meta, nil
+	}
+
+	now := time.Now()
+	meta := MetaObject{Oid: v.Oid, Size: v.Size, User: v.User, CreatedAt: now, LastAccessAt: now}
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	err := enc.Encode(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.updateDB(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(objectsBucket)
+		if bucket == nil {
+			return errNoBucket
+		}
+
+		if err := bucket.Put([]byte(v.Oid), buf.Bytes()); err != nil {
+			return err
+		}
+
+		byUser := tx.Bucket(objectsByUserBucket)
+		if byUser == nil {
+			return errNoBucket
+		}
+		if err := byUser.Put(objectsByUserKey(meta.User, meta.CreatedAt, meta.Oid), nil); err != nil {
+			return err
+		}
+
+		byTime := tx.Bucket(objectsByTimeBucket)
+		if byTime == nil {
+			return errNoBucket
+		}
+		return byTime.Put(objectsByTimeKey(meta.CreatedAt, meta.Oid), nil)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+// AtomicPut performs a compare-and-swap write of v inside a single boltdb
+// Update transaction: the stored MetaObject's Version must equal
+// expected.Version (nil expected means "must not already exist"), or the
+// write is rejected with ErrModified.
+func (s *BoltMetaStore) AtomicPut(v *RequestVars, expected *MetaObject) (*MetaObject, bool, error) {
+	if _, err := authenticateRequest(s, v.Authorization, RoleWriter); err != nil {
+		return nil, false, err
+	}
+
+	var meta MetaObject
+
+	err := s.updateDB(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(objectsBucket)
+		if bucket == nil {
+			return errNoBucket
+		}
+
+		var current MetaObject
+		var found bool
+		if existing := bucket.Get([]byte(v.Oid)); len(existing) > 0 {
+			dec := gob.NewDecoder(bytes.NewBuffer(existing))
+			if err := dec.Decode(&current); err != nil {
+				return err
+			}
+			found = true
+		}
+
+		if expected == nil {
+			if found {
+				return ErrModified
+			}
+		} else if !found || current.Version != expected.Version {
+			return ErrModified
+		}
+
+		meta = MetaObject{
+			Oid:       v.Oid,
+			Size:      v.Size,
+			User:      v.User,
+			CreatedAt: current.CreatedAt,
+			Version:   current.Version + 1,
+		}
+		if meta.CreatedAt.IsZero() {
+			meta.CreatedAt = time.Now()
+		}
+
+		var buf bytes.Buffer
+		enc := gob.NewEncoder(&buf)
+		if err := enc.Encode(meta); err != nil {
+			return err
+		}
+
+		if err := bucket.Put([]byte(v.Oid), buf.Bytes()); err != nil {
+			return err
+		}
+
+		byUser := tx.Bucket(objectsByUserBucket)
+		if byUser == nil {
+			return errNoBucket
+		}
+		if err := byUser.Put(objectsByUserKey(meta.User, meta.CreatedAt, meta.Oid), nil); err != nil {
+			return err
+		}
+
+		byTime := tx.Bucket(objectsByTimeBucket)
+		if byTime == nil {
+			return errNoBucket
+		}
+		return byTime.Put(objectsByTimeKey(meta.CreatedAt, meta.Oid), nil)
+	})
+
+	if err == ErrModified {
+		return nil, false, ErrModified
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &meta, true, nil
+}
+
+// AtomicDelete performs a compare-and-swap delete of v.Oid inside a single
+// boltdb Update transaction: the stored MetaObject's Version must equal
+// expected.Version, or the delete is rejected with ErrModified.
+func (s *BoltMetaStore) AtomicDelete(v *RequestVars, expected *MetaObject) (bool, error) {
+	if _, err := authenticateRequest(s, v.Authorization, RoleWriter); err != nil {
+		return false, err
+	}
+
+	oid := v.Oid
+	err := s.updateDB(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(objectsBucket)
+		if bucket == nil {
+			return errNoBucket
+		}
+
+		existing := bucket.Get([]byte(oid))
+		if len(existing) == 0 {
+			return errObjectNotFound
+		}
+
+		var current MetaObject
+		dec := gob.NewDecoder(bytes.NewBuffer(existing))
+		if err := dec.Decode(&current); err != nil {
+			return err
+		}
+
+		var expectedVersion uint64
+		if expected != nil {
+			expectedVersion = expected.Version
+		}
+		if current.Version != expectedVersion {
+			return ErrModified
+		}
+
+		if err := bucket.Delete([]byte(oid)); err != nil {
+			return err
+		}
+
+		if byUser := tx.Bucket(objectsByUserBucket); byUser != nil {
+			byUser.Delete(objectsByUserKey(current.User, current.CreatedAt, oid))
+		}
+		if byTime := tx.Bucket(objectsByTimeBucket); byTime != nil {
+			byTime.Delete(objectsByTimeKey(current.CreatedAt, oid))
+		}
+
+		return nil
+	})
+
+	if err == ErrModified {
+		return false, ErrModified
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// objectsByUserKey builds the objects_by_user index key for a MetaObject,
+// ordered so that a prefix scan on "<user>/" walks its objects oldest-first.
+func objectsByUserKey(user string, t time.Time, oid string) []byte {
+	return []byte(fmt.Sprintf("%s/%020d/%s", user, t.UnixNano(), oid))
+}
+
+// objectsByTimeKey builds the objects_by_time index key for a MetaObject,
+// ordered so that a prefix scan walks all objects oldest-first.
+func objectsByTimeKey(t time.Time, oid string) []byte {
+	return []byte(fmt.Sprintf("%020d/%s", t.UnixNano(), oid))
+}
+
+// Close closes the underlying boltdb.
+func (s *BoltMetaStore) Close() {
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+	s.db.Close()
+}
+
+// AddUser adds user credentials to the meta store, bcrypt-hashing pass and
+// recording role.
+func (s *BoltMetaStore) AddUser(user, pass string, role Role) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	mu := MetaUser{Name: user, PasswordHash: hash, Role: role, CreatedAt: time.Now()}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(mu); err != nil {
+		return err
+	}
+
+	return s.updateDB(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(usersBucket)
+		if bucket == nil {
+			return errNoBucket
+		}
+
+		return bucket.Put([]byte(user), buf.Bytes())
+	})
+}
+
+// SetPassword updates user's bcrypt-hashed password.
+func (s *BoltMetaStore) SetPassword(user, pass string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return s.updateDB(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(usersBucket)
+		if bucket == nil {
+			return errNoBucket
+		}
+
+		value := bucket.Get([]byte(user))
+		if len(value) == 0 {
+			return errUserNotFound
+		}
+
+		mu, _, err := decodeOrMigrateMetaUser(user, value)
+		if err != nil {
+			return err
+		}
+		mu.PasswordHash = hash
+
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(mu); err != nil {
+			return err
+		}
+		return bucket.Put([]byte(user), buf.Bytes())
+	})
+}
+
+// Disable marks user as disabled so Authenticate rejects their credentials.
+func (s *BoltMetaStore) Disable(user string) error {
+	return s.updateDB(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(usersBucket)
+		if bucket == nil {
+			return errNoBucket
+		}
+
+		value := bucket.Get([]byte(user))
+		if len(value) == 0 {
+			return errUserNotFound
+		}
+
+		mu, _, err := decodeOrMigrateMetaUser(user, value)
+		if err != nil {
+			return err
+		}
+		mu.Disabled = true
+
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(mu); err != nil {
+			return err
+		}
+		return bucket.Put([]byte(user), buf.Bytes())
+	})
+}
+
+// Authenticate verifies user/pass against the stored bcrypt hash and returns
+// the resolved MetaUser on success. Entries written before MetaUser existed
+// are raw plaintext passwords; those are transparently upgraded to a
+// bcrypt-hashed MetaUser with the default reader role the first time they're
+// authenticated.
+func (s *BoltMetaStore) Authenticate(user, pass string) (*MetaUser, error) {
+	var result MetaUser
+
+	err := s.updateDB(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(usersBucket)
+		if bucket == nil {
+			return errNoBucket
+		}
+
+		value := bucket.Get([]byte(user))
+		if len(value) == 0 {
+			return errUserNotFound
+		}
+
+		mu, migrated, err := decodeOrMigrateMetaUser(user, value)
+		if err != nil {
+			return err
+		}
+
+		if migrated {
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(mu); err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(user), buf.Bytes()); err != nil {
+				return err
+			}
+		}
+
+		result = mu
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Disabled {
+		return nil, errUserDisabled
+	}
+
+	if err := bcrypt.CompareHashAndPassword(result.PasswordHash, []byte(pass)); err != nil {
+		return nil, errAuthFailed
+	}
+
+	return &result, nil
+}
+
+// decodeOrMigrateMetaUser decodes a users bucket value as a gob-encoded
+// MetaUser. Values written before MetaUser existed are raw plaintext
+// passwords; those are upgraded in place to a bcrypt-hashed MetaUser with
+// the default reader role, and the second return value reports whether that
+// migration happened so the caller can persist it.
+func decodeOrMigrateMetaUser(user string, value []byte) (MetaUser, bool, error) {
+	var mu MetaUser
+	if err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(&mu); err == nil {
+		return mu, false, nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword(value, bcrypt.DefaultCost)
+	if err != nil {
+		return MetaUser{}, false, err
+	}
+
+	return MetaUser{
+		Name:         user,
+		PasswordHash: hash,
+		Role:         RoleReader,
+		CreatedAt:    time.Now(),
+	}, true, nil
+}
+
+// DeleteUser removes user credentials from the meta store.
+func (s *BoltMetaStore) DeleteUser(user string) error {
+	err := s.updateDB(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(usersBucket)
+		if bucket == nil {
+			return errNoBucket
+		}
+
+		err := bucket.Delete([]byte(user))
+		return err
+	})
+
+	return err
+}
+
+// Users returns all MetaUsers in the meta store
+func (s *BoltMetaStore) Users() ([]*MetaUser, error) {
+	var users []*MetaUser
+
+	err := s.viewDB(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(usersBucket)
+		if bucket == nil {
+			return errNoBucket
+		}
+
+		bucket.ForEach(func(k, v []byte) error {
+			mu, _, err := decodeOrMigrateMetaUser(string(k), v)
+			if err != nil {
+				return err
+			}
+			users = append(users, &mu)
+			return nil
+		})
+		return nil
+	})
+
+	return users, err
+}
+
+// ObjectsByUser returns, at most, limit MetaObjects uploaded by user, ordered
+// oldest first. If sinceOid is non-empty, results resume after that oid
+// rather than starting from the beginning, so callers can page through a
+// user's objects without re-scanning what they've already seen.
+func (s *BoltMetaStore) ObjectsByUser(user string, sinceOid string, limit int) ([]*MetaObject, error) {
+	var objects []*MetaObject
+
+	err := s.viewDB(func(tx *bolt.Tx) error {
+		objBucket := tx.Bucket(objectsBucket)
+		if objBucket == nil {
+			return errNoBucket
+		}
+
+		byUser := tx.Bucket(objectsByUserBucket)
+		if byUser == nil {
+			return errNoBucket
+		}
+
+		prefix := []byte(user + "/")
+		c := byUser.Cursor()
+		skipping := sinceOid != ""
+
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			oid := oidFromIndexKey(k)
+
+			if skipping {
+				if oid == sinceOid {
+					skipping = false
+				}
+				continue
+			}
+
+			meta, err := decodeMetaObject(objBucket, oid)
+			if err != nil {
+				return err
+			}
+			objects = append(objects, meta)
+
+			if limit > 0 && len(objects) >= limit {
+				return nil
+			}
+		}
+
+		return nil
+	})
+
+	return objects, err
+}
+
+// ObjectsSince returns, at most, limit MetaObjects created at or after t,
+// ordered oldest first. It walks the objects_by_time index instead of
+// scanning and decoding the whole objects bucket.
+func (s *BoltMetaStore) ObjectsSince(t time.Time, limit int) ([]*MetaObject, error) {
+	var objects []*MetaObject
+
+	err := s.viewDB(func(tx *bolt.Tx) error {
+		objBucket := tx.Bucket(objectsBucket)
+		if objBucket == nil {
+			return errNoBucket
+		}
+
+		byTime := tx.Bucket(objectsByTimeBucket)
+		if byTime == nil {
+			return errNoBucket
+		}
+
+		seek := []byte(fmt.Sprintf("%020d", t.UnixNano()))
+		c := byTime.Cursor()
+
+		for k, _ := c.Seek(seek); k != nil; k, _ = c.Next() {
+			meta, err := decodeMetaObject(objBucket, oidFromIndexKey(k))
+			if err != nil {
+				return err
+			}
+			objects = append(objects, meta)
+
+			if limit > 0 && len(objects) >= limit {
+				return nil
+			}
+		}
+
+		return nil
+	})
+
+	return objects, err
+}
+
+// oidFromIndexKey extracts the oid suffix from a "<prefix>/<oid>" index key.
+func oidFromIndexKey(key []byte) string {
+	parts := bytes.Split(key, []byte("/"))
+	return string(parts[len(parts)-1])
+}
+
+// decodeMetaObject looks up and gob-decodes the MetaObject stored under oid
+// in the objects bucket.
+func decodeMetaObject(objBucket *bolt.Bucket, oid string) (*MetaObject, error) {
+	value := objBucket.Get([]byte(oid))
+	if len(value) == 0 {
+		return nil, errObjectNotFound
+	}
+
+	var meta MetaObject
+	dec := gob.NewDecoder(bytes.NewBuffer(value))
+	if err := dec.Decode(&meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// Objects returns all MetaObjects in the meta store
+func (s *BoltMetaStore) Objects() ([]*MetaObject, error) {
+	var objects []*MetaObject
+
+	err := s.viewDB(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(objectsBucket)
+		if bucket == nil {
+			return errNoBucket
+		}
+
+		bucket.ForEach(func(k, v []byte) error {
+			var meta MetaObject
+			dec := gob.NewDecoder(bytes.NewBuffer(v))
+			err := dec.Decode(&meta)
+			if err != nil {
+				return err
+			}
+			objects = append(objects, &meta)
+			return nil
+		})
+		return nil
+	})
+
+	return objects, err
+}
+
+// GC walks the objects_by_time index oldest-first, deleting any MetaObject
+// whose ExpiresAt has passed or whose blob policy.BlobExists reports
+// missing. Each deletion runs in its own boltdb Update transaction so a
+// large sweep doesn't hold one long-lived write lock, and ctx is checked
+// between transactions so callers can bound how long a pass runs.
+// nextGCCandidate is handed the last index key it examined so each call
+// resumes from there instead of re-walking the index from the front, which
+// keeps a pass over a large store with sparse expirations O(N) rather than
+// O(N) per deletion.
+func (s *BoltMetaStore) GC(ctx context.Context, policy GCPolicy) (int, error) {
+	removed := 0
+	now := time.Now()
+
+	var after []byte
+	for {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+
+		oid, indexKey, shouldDelete, done, err := s.nextGCCandidate(after, now, policy)
+		if err != nil {
+			return removed, err
+		}
+		if done {
+			return removed, nil
+		}
+		after = indexKey
+
+		if !shouldDelete {
+			continue
+		}
+
+		if err := s.deleteMetaObject(oid); err != nil {
+			return removed, err
+		}
+		removed++
+
+		if policy.OnDelete != nil {
+			policy.OnDelete(oid)
+		}
+	}
+}
+
+// nextGCCandidate returns the first objects_by_time entry after the given
+// index key (nil after means "start from the front") along with whether
+// it's eligible for deletion. done reports that the index is exhausted.
+func (s *BoltMetaStore) nextGCCandidate(after []byte, now time.Time, policy GCPolicy) (oid string, indexKey []byte, shouldDelete bool, done bool, err error) {
+	err = s.viewDB(func(tx *bolt.Tx) error {
+		byTime := tx.Bucket(objectsByTimeBucket)
+		if byTime == nil {
+			return errNoBucket
+		}
+		objBucket := tx.Bucket(objectsBucket)
+		if objBucket == nil {
+			return errNoBucket
+		}
+
+		c := byTime.Cursor()
+		var k []byte
+		if after == nil {
+			k, _ = c.First()
+		} else {
+			k, _ = c.Seek(after)
+			if k != nil && bytes.Equal(k, after) {
+				k, _ = c.Next()
+			}
+		}
+		if k == nil {
+			done = true
+			return nil
+		}
+		indexKey = append([]byte(nil), k...)
+
+		candidate := oidFromIndexKey(k)
+		meta, derr := decodeMetaObject(objBucket, candidate)
+		if derr == errObjectNotFound {
+			// Index entry with no matching object; treat it as stale and
+			// let deleteMetaObject clean it up.
+			oid = candidate
+			shouldDelete = true
+			return nil
+		}
+		if derr != nil {
+			return derr
+		}
+
+		expired := !meta.ExpiresAt.IsZero() && meta.ExpiresAt.Before(now)
+		missingBlob := policy.BlobExists != nil && !policy.BlobExists(candidate)
+		if expired || missingBlob {
+			oid = candidate
+			shouldDelete = true
+		}
+		return nil
+	})
+
+	return oid, indexKey, shouldDelete, done, err
+}
+
+// deleteMetaObject removes oid's metadata and secondary index entries in a
+// single Update transaction.
+func (s *BoltMetaStore) deleteMetaObject(oid string) error {
+	return s.updateDB(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(objectsBucket)
+		if bucket == nil {
+			return errNoBucket
+		}
+
+		var meta MetaObject
+		hadMeta := false
+		if value := bucket.Get([]byte(oid)); len(value) > 0 {
+			if err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(&meta); err != nil {
+				return err
+			}
+			hadMeta = true
+		}
+
+		if err := bucket.Delete([]byte(oid)); err != nil {
+			return err
+		}
+
+		if hadMeta {
+			if byUser := tx.Bucket(objectsByUserBucket); byUser != nil {
+				byUser.Delete(objectsByUserKey(meta.User, meta.CreatedAt, oid))
+			}
+			if byTime := tx.Bucket(objectsByTimeBucket); byTime != nil {
+				byTime.Delete(objectsByTimeKey(meta.CreatedAt, oid))
+			}
+			return nil
+		}
+
+		// Index entry had no matching object: sweep every objects_by_time
+		// key for this oid, since we don't know its original timestamp.
+		byTime := tx.Bucket(objectsByTimeBucket)
+		if byTime == nil {
+			return errNoBucket
+		}
+		c := byTime.Cursor()
+		suffix := []byte("/" + oid)
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if bytes.HasSuffix(k, suffix) {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
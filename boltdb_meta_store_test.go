@@ -0,0 +1,111 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestBoltMetaStore returns a BoltMetaStore backed by a fresh temp file,
+// with a single writer user, ready to drive Put/ObjectsByUser/ObjectsSince
+// in tests. The store is closed automatically when the test ends.
+func newTestBoltMetaStore(t *testing.T) *BoltMetaStore {
+	t.Helper()
+
+	s, err := NewBoltMetaStore(filepath.Join(t.TempDir(), "meta.db"))
+	if err != nil {
+		t.Fatalf("NewBoltMetaStore: %v", err)
+	}
+	t.Cleanup(s.Close)
+
+	if err := s.AddUser("writer", "pass", RoleWriter); err != nil {
+		t.Fatalf("AddUser: %v", err)
+	}
+
+	return s
+}
+
+func putTestObject(t *testing.T, s *BoltMetaStore, user, oid string) {
+	t.Helper()
+
+	v := &RequestVars{
+		Oid:           oid,
+		Size:          4,
+		User:          user,
+		Authorization: basicAuthHeader("writer", "pass"),
+	}
+	if _, err := s.Put(v); err != nil {
+		t.Fatalf("Put(%q): %v", oid, err)
+	}
+}
+
+func TestBoltMetaStore_ObjectsByUser_PrefixWalkAndResume(t *testing.T) {
+	s := newTestBoltMetaStore(t)
+
+	putTestObject(t, s, "alice", "oid-a1")
+	putTestObject(t, s, "bob", "oid-b1")
+	putTestObject(t, s, "alice", "oid-a2")
+	putTestObject(t, s, "alice", "oid-a3")
+
+	all, err := s.ObjectsByUser("alice", "", 0)
+	if err != nil {
+		t.Fatalf("ObjectsByUser: %v", err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("ObjectsByUser(alice) returned %d objects, want 3", len(all))
+	}
+	for _, meta := range all {
+		if meta.User != "alice" {
+			t.Fatalf("ObjectsByUser(alice) returned object for user %q", meta.User)
+		}
+	}
+
+	// Resuming after oid-a1 must skip it and continue from the next one,
+	// without needing to re-scan from the start.
+	resumed, err := s.ObjectsByUser("alice", "oid-a1", 0)
+	if err != nil {
+		t.Fatalf("ObjectsByUser with sinceOid: %v", err)
+	}
+	if len(resumed) != 2 {
+		t.Fatalf("ObjectsByUser(alice, sinceOid=oid-a1) returned %d objects, want 2", len(resumed))
+	}
+	for _, meta := range resumed {
+		if meta.Oid == "oid-a1" {
+			t.Fatalf("ObjectsByUser with sinceOid=oid-a1 still returned oid-a1")
+		}
+	}
+
+	limited, err := s.ObjectsByUser("alice", "", 1)
+	if err != nil {
+		t.Fatalf("ObjectsByUser with limit: %v", err)
+	}
+	if len(limited) != 1 {
+		t.Fatalf("ObjectsByUser(alice, limit=1) returned %d objects, want 1", len(limited))
+	}
+}
+
+func TestBoltMetaStore_ObjectsSince_OrderedByTime(t *testing.T) {
+	s := newTestBoltMetaStore(t)
+
+	putTestObject(t, s, "alice", "oid-1")
+	putTestObject(t, s, "alice", "oid-2")
+
+	// Everything created so far should be returned when querying from well
+	// before the first Put.
+	objects, err := s.ObjectsSince(time.Now().Add(-time.Hour), 0)
+	if err != nil {
+		t.Fatalf("ObjectsSince: %v", err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("ObjectsSince returned %d objects, want 2", len(objects))
+	}
+
+	// Querying from the future should return nothing.
+	none, err := s.ObjectsSince(time.Now().Add(time.Hour), 0)
+	if err != nil {
+		t.Fatalf("ObjectsSince (future): %v", err)
+	}
+	if len(none) != 0 {
+		t.Fatalf("ObjectsSince(future) returned %d objects, want 0", len(none))
+	}
+}
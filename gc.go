@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// StartGC runs store.GC on a fixed interval until ctx is cancelled. It's
+// meant to be started as a goroutine from server startup when GC is enabled
+// in config; any error is logged, as is the count of each pass that removed
+// at least one object.
+func StartGC(ctx context.Context, store MetaStore, interval time.Duration, policy GCPolicy) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			removed, err := store.GC(ctx, policy)
+			if err != nil {
+				logger.Log(kv{"fn": "gc", "msg": err.Error()})
+				continue
+			}
+			if removed > 0 {
+				logger.Log(kv{"fn": "gc", "msg": "removed orphaned objects", "count": removed})
+			}
+		}
+	}
+}
+
+// GCHandler runs one store.GC pass synchronously and reports how many
+// objects it removed, for admins who want to trigger an out-of-band sweep
+// (e.g. right after removing blobs from the content store) without waiting for
+// StartGC's next tick. It requires the request's HTTP Basic Authorization
+// header to resolve to a RoleAdmin user.
+func GCHandler(store MetaStore, policy GCPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if _, err := authenticateRequest(store, r.Header.Get("Authorization"), RoleAdmin); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		removed, err := store.GC(r.Context(), policy)
+		if err != nil {
+			logger.Log(kv{"fn": "admin_gc", "msg": err.Error()})
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprintf(w, "removed %d objects\n", removed)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// memorySnapshot is the gob-encoded wire format used by
+// MemoryMetaStore.Backup/Restore.
+type memorySnapshot struct {
+	Users   map[string]*MetaUser
+	Objects map[string]*MetaObject
+}
+
+// MemoryMetaStore implements MetaStore entirely in memory. It is not
+// persisted across restarts and is intended for tests and local
+// experimentation.
+type MemoryMetaStore struct {
+	mu      sync.Mutex
+	users   map[string]*MetaUser
+	objects map[string]*MetaObject
+}
+
+// NewMemoryMetaStore creates a new, empty MemoryMetaStore.
+func NewMemoryMetaStore() *MemoryMetaStore {
+	return &MemoryMetaStore{
+		users:   make(map[string]*MetaUser),
+		objects: make(map[string]*MetaObject),
+	}
+}
+
+// Get retrieves the Meta information for an object given information in
+// RequestVars
+func (s *MemoryMetaStore) Get(v *RequestVars) (*MetaObject, error) {
+	if _, err := authenticateRequest(s, v.Authorization, RoleReader); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, ok := s.objects[v.Oid]
+	if !ok {
+		return nil, errObjectNotFound
+	}
+
+	meta.LastAccessAt = time.Now()
+
+	copied := *meta
+	return &copied, nil
+}
+
+// Put writes meta information from RequestVars to the store.
+func (s *MemoryMetaStore) Put(v *RequestVars) (*MetaObject, error) {
+	if _, err := authenticateRequest(s, v.Authorization, RoleWriter); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if meta, ok := s.objects[v.Oid]; ok {
+		copied := *meta
+		copied.Existing = true
+		return &copied, nil
+	}
+
+	now := time.Now()
+	meta := &MetaObject{Oid: v.Oid, Size: v.Size, User: v.User, CreatedAt: now, LastAccessAt: now}
+	s.objects[v.Oid] = meta
+
+	copied := *meta
+	return &copied, nil
+}
+
+// AtomicPut performs a compare-and-swap write of v: the stored MetaObject's
+// Version must equal expected.Version (nil expected means "must not already
+// exist"), or the write is rejected with ErrModified.
+func (s *MemoryMetaStore) AtomicPut(v *RequestVars, expected *MetaObject) (*MetaObject, bool, error) {
+	if _, err := authenticateRequest(s, v.Authorization, RoleWriter); err != nil {
+		return nil, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := s.objects[v.Oid]
+
+	if expected == nil {
+		if current != nil {
+			return nil, false, ErrModified
+		}
+	} else if current == nil || current.Version != expected.Version {
+		return nil, false, ErrModified
+	}
+
+	createdAt := time.Now()
+	var currentVersion uint64
+	if current != nil {
+		currentVersion = current.Version
+		createdAt = current.CreatedAt
+	}
+
+	meta := &MetaObject{Oid: v.Oid, Size: v.Size, User: v.User, CreatedAt: createdAt, Version: currentVersion + 1}
+	s.objects[v.Oid] = meta
+
+	copied := *meta
+	return &copied, true, nil
+}
+
+// AtomicDelete performs a compare-and-swap delete of v.Oid: the stored
+// MetaObject's Version must equal expected.Version, or the delete is
+// rejected with ErrModified.
+func (s *MemoryMetaStore) AtomicDelete(v *RequestVars, expected *MetaObject) (bool, error) {
+	if _, err := authenticateRequest(s, v.Authorization, RoleWriter); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oid := v.Oid
+	current, ok := s.objects[oid]
+	if !ok {
+		return false, errObjectNotFound
+	}
+
+	var expectedVersion uint64
+	if expected != nil {
+		expectedVersion = expected.Version
+	}
+	if current.Version != expectedVersion {
+		return false, ErrModified
+	}
+
+	delete(s.objects, oid)
+	return true, nil
+}
+
+// Close is a no-op for MemoryMetaStore.
+func (s *MemoryMetaStore) Close() {}
+
+// AddUser adds user credentials to the meta store, bcrypt-hashing pass and
+// recording role.
+func (s *MemoryMetaStore) AddUser(user, pass string, role Role) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[user] = &MetaUser{Name: user, PasswordHash: hash, Role: role, CreatedAt: time.Now()}
+	return nil
+}
+
+// SetPassword updates user's bcrypt-hashed password.
+func (s *MemoryMetaStore) SetPassword(user, pass string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mu, ok := s.users[user]
+	if !ok {
+		return errUserNotFound
+	}
+	mu.PasswordHash = hash
+	return nil
+}
+
+// Disable marks user as disabled so Authenticate rejects their credentials.
+func (s *MemoryMetaStore) Disable(user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mu, ok := s.users[user]
+	if !ok {
+		return errUserNotFound
+	}
+	mu.Disabled = true
+	return nil
+}
+
+// Authenticate verifies user/pass against the stored bcrypt hash and returns
+// the resolved MetaUser on success.
+func (s *MemoryMetaStore) Authenticate(user, pass string) (*MetaUser, error) {
+	s.mu.Lock()
+	mu, ok := s.users[user]
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, errUserNotFound
+	}
+	if mu.Disabled {
+		return nil, errUserDisabled
+	}
+	if err := bcrypt.CompareHashAndPassword(mu.PasswordHash, []byte(pass)); err != nil {
+		return nil, errAuthFailed
+	}
+
+	copied := *mu
+	return &copied, nil
+}
+
+// DeleteUser removes user credentials from the meta store.
+func (s *MemoryMetaStore) DeleteUser(user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.users, user)
+	return nil
+}
+
+// Users returns all MetaUsers in the meta store
+func (s *MemoryMetaStore) Users() ([]*MetaUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]*MetaUser, 0, len(s.users))
+	for _, mu := range s.users {
+		copied := *mu
+		users = append(users, &copied)
+	}
+	return users, nil
+}
+
+// Objects returns all MetaObjects in the meta store
+func (s *MemoryMetaStore) Objects() ([]*MetaObject, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objects := make([]*MetaObject, 0, len(s.objects))
+	for _, meta := range s.objects {
+		copied := *meta
+		objects = append(objects, &copied)
+	}
+	return objects, nil
+}
+
+// GC deletes any MetaObject whose ExpiresAt has passed or whose blob
+// policy.BlobExists reports missing, invoking policy.OnDelete for each one
+// removed.
+func (s *MemoryMetaStore) GC(ctx context.Context, policy GCPolicy) (int, error) {
+	now := time.Now()
+	removed := 0
+
+	s.mu.Lock()
+	var toDelete []string
+	for oid, meta := range s.objects {
+		if err := ctx.Err(); err != nil {
+			s.mu.Unlock()
+			return removed, err
+		}
+
+		expired := !meta.ExpiresAt.IsZero() && meta.ExpiresAt.Before(now)
+		missingBlob := policy.BlobExists != nil && !policy.BlobExists(oid)
+		if expired || missingBlob {
+			toDelete = append(toDelete, oid)
+		}
+	}
+	for _, oid := range toDelete {
+		delete(s.objects, oid)
+	}
+	s.mu.Unlock()
+
+	for _, oid := range toDelete {
+		removed++
+		if policy.OnDelete != nil {
+			policy.OnDelete(oid)
+		}
+	}
+
+	return removed, nil
+}
+
+// Backup gob-encodes the entire in-memory state to w.
+func (s *MemoryMetaStore) Backup(w io.Writer) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := memorySnapshot{Users: s.users, Objects: s.objects}
+
+	counting := &countingWriter{w: w}
+	if err := gob.NewEncoder(counting).Encode(snapshot); err != nil {
+		return counting.n, err
+	}
+	return counting.n, nil
+}
+
+// Restore replaces the in-memory state with a snapshot previously written
+// by Backup.
+func (s *MemoryMetaStore) Restore(r io.Reader) error {
+	var snapshot memorySnapshot
+	if err := gob.NewDecoder(r).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users = snapshot.Users
+	s.objects = snapshot.Objects
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+// basicAuthHeader builds the value of an HTTP Basic Authorization header
+// for user/pass, as tests need to populate RequestVars.Authorization or an
+// admin request's header.
+func basicAuthHeader(user, pass string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+}
+
+// newTestMemoryMetaStore returns a MemoryMetaStore with a single writer user
+// and a RequestVars pre-populated with credentials for it, ready to drive
+// Get/Put/AtomicPut/AtomicDelete in tests.
+func newTestMemoryMetaStore(t *testing.T) (*MemoryMetaStore, *RequestVars) {
+	t.Helper()
+
+	s := NewMemoryMetaStore()
+	if err := s.AddUser("writer", "pass", RoleWriter); err != nil {
+		t.Fatalf("AddUser: %v", err)
+	}
+
+	return s, &RequestVars{
+		Oid:           "deadbeef",
+		Size:          4,
+		User:          "writer",
+		Authorization: basicAuthHeader("writer", "pass"),
+	}
+}
+
+func TestMemoryMetaStore_AtomicPut_RejectsOverwriteOfUnversionedObject(t *testing.T) {
+	s, v := newTestMemoryMetaStore(t)
+
+	if _, err := s.Put(v); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// The object above was created via the ordinary, non-atomic Put, so it
+	// was never assigned a Version: it's left at the zero value. A
+	// "must not already exist" AtomicPut against it must still be rejected
+	// with ErrModified, not silently overwrite it.
+	if _, ok, err := s.AtomicPut(v, nil); err != ErrModified || ok {
+		t.Fatalf("AtomicPut(nil) against existing unversioned object = (ok=%v, err=%v), want ErrModified", ok, err)
+	}
+}
+
+func TestMemoryMetaStore_AtomicPut_CAS(t *testing.T) {
+	s, v := newTestMemoryMetaStore(t)
+
+	meta, ok, err := s.AtomicPut(v, nil)
+	if err != nil || !ok {
+		t.Fatalf("AtomicPut(nil) on new object = (ok=%v, err=%v), want success", ok, err)
+	}
+	if meta.Version != 1 {
+		t.Fatalf("Version = %d, want 1", meta.Version)
+	}
+
+	if _, ok, err := s.AtomicPut(v, nil); err != ErrModified || ok {
+		t.Fatalf("AtomicPut(nil) against existing object = (ok=%v, err=%v), want ErrModified", ok, err)
+	}
+
+	stale := &MetaObject{Version: meta.Version - 1}
+	if _, ok, err := s.AtomicPut(v, stale); err != ErrModified || ok {
+		t.Fatalf("AtomicPut(stale) = (ok=%v, err=%v), want ErrModified", ok, err)
+	}
+
+	meta2, ok, err := s.AtomicPut(v, meta)
+	if err != nil || !ok {
+		t.Fatalf("AtomicPut(current) = (ok=%v, err=%v), want success", ok, err)
+	}
+	if meta2.Version != meta.Version+1 {
+		t.Fatalf("Version = %d, want %d", meta2.Version, meta.Version+1)
+	}
+}
+
+func TestMemoryMetaStore_AtomicDelete_CAS(t *testing.T) {
+	s, v := newTestMemoryMetaStore(t)
+
+	meta, ok, err := s.AtomicPut(v, nil)
+	if err != nil || !ok {
+		t.Fatalf("AtomicPut(nil) on new object = (ok=%v, err=%v), want success", ok, err)
+	}
+
+	stale := &MetaObject{Version: meta.Version - 1}
+	if ok, err := s.AtomicDelete(v, stale); err != ErrModified || ok {
+		t.Fatalf("AtomicDelete(stale) = (ok=%v, err=%v), want ErrModified", ok, err)
+	}
+
+	if ok, err := s.AtomicDelete(v, meta); err != nil || !ok {
+		t.Fatalf("AtomicDelete(current) = (ok=%v, err=%v), want success", ok, err)
+	}
+
+	if _, err := s.Get(v); err != errObjectNotFound {
+		t.Fatalf("Get after AtomicDelete = %v, want errObjectNotFound", err)
+	}
+
+	if ok, err := s.AtomicDelete(v, meta); err != errObjectNotFound || ok {
+		t.Fatalf("AtomicDelete on already-deleted object = (ok=%v, err=%v), want errObjectNotFound", ok, err)
+	}
+}
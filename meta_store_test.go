@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestNewMetaStore_DriverDispatch(t *testing.T) {
+	cases := []struct {
+		name       string
+		driverType string
+		wantType   interface{}
+	}{
+		{"default", "", &BoltMetaStore{}},
+		{"boltdb", "boltdb", &BoltMetaStore{}},
+		{"memory", "memory", &MemoryMetaStore{}},
+		{"pebble", "pebble", &PebbleMetaStore{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := &Config{MetaStoreType: c.driverType, MetaDB: t.TempDir() + "/meta.db"}
+
+			store, err := NewMetaStore(cfg)
+			if err != nil {
+				t.Fatalf("NewMetaStore(%q): %v", c.driverType, err)
+			}
+			defer store.Close()
+
+			switch c.wantType.(type) {
+			case *BoltMetaStore:
+				if _, ok := store.(*BoltMetaStore); !ok {
+					t.Fatalf("NewMetaStore(%q) = %T, want *BoltMetaStore", c.driverType, store)
+				}
+			case *MemoryMetaStore:
+				if _, ok := store.(*MemoryMetaStore); !ok {
+					t.Fatalf("NewMetaStore(%q) = %T, want *MemoryMetaStore", c.driverType, store)
+				}
+			case *PebbleMetaStore:
+				if _, ok := store.(*PebbleMetaStore); !ok {
+					t.Fatalf("NewMetaStore(%q) = %T, want *PebbleMetaStore", c.driverType, store)
+				}
+			}
+		})
+	}
+}
+
+func TestNewMetaStore_UnknownDriver(t *testing.T) {
+	cfg := &Config{MetaStoreType: "nonsense", MetaDB: t.TempDir() + "/meta.db"}
+
+	if _, err := NewMetaStore(cfg); err == nil {
+		t.Fatalf("NewMetaStore(%q) = nil error, want an error", cfg.MetaStoreType)
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import "net/http"
+
+// RegisterAdminRoutes mounts the admin-only backup/restore/GC endpoints on
+// mux: GET /admin/backup, POST /admin/restore, and POST /admin/gc. Each
+// handler independently re-checks for a RoleAdmin user via its
+// Authorization header, so mux itself carries no auth logic.
+//
+// No server bootstrap exists yet in this tree to call this at startup
+// (there is no main.go/server.go in this snapshot); callers wiring up the
+// real HTTP server should call this against their top-level mux alongside
+// the object-storage routes.
+func RegisterAdminRoutes(mux *http.ServeMux, store MetaStore, policy GCPolicy) {
+	mux.Handle("/admin/backup", BackupHandler(store))
+	mux.Handle("/admin/restore", RestoreHandler(store))
+	mux.Handle("/admin/gc", GCHandler(store, policy))
+}
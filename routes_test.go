@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegisterAdminRoutes(t *testing.T) {
+	s, v := newTestMemoryMetaStore(t)
+	if err := s.AddUser("admin", "pass", RoleAdmin); err != nil {
+		t.Fatalf("AddUser: %v", err)
+	}
+	if _, err := s.Put(v); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	RegisterAdminRoutes(mux, s, GCPolicy{})
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/backup", nil)
+	req.Header.Set("Authorization", basicAuthHeader("admin", "pass"))
+	rec := httptest.NewRecorder()
+
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /admin/backup through mux = status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec.Body.Len() == 0 {
+		t.Fatalf("GET /admin/backup through mux returned empty body")
+	}
+}